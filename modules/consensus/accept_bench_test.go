@@ -9,6 +9,7 @@ import (
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/modules/gateway"
+	"github.com/NebulousLabs/Sia/modules/txpool"
 	"github.com/NebulousLabs/Sia/types"
 )
 
@@ -170,3 +171,80 @@ func BenchmarkAcceptBigTxBlocks(b *testing.B) {
 		b.StopTimer()
 	}
 }
+
+// BenchmarkAcceptPooledTxBlocks mirrors BenchmarkAcceptBigTxBlocks, but
+// sources its block's transactions from a txpool.TransactionPool instead of
+// constructing a single transaction inline. This is what
+// cst.miner.FindBlock does internally once wired to the pool, and lets the
+// benchmark reflect realistic multi-transaction block assembly under
+// fee-priority ordering.
+func BenchmarkAcceptPooledTxBlocks(b *testing.B) {
+	b.ReportAllocs()
+
+	numSigs := 7
+
+	cst, err := createConsensusSetTester("BenchmarkEmptyBlocksC")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Mine until the wallet has enough utxos to submit numSigs transactions.
+	for cst.cs.height() < (types.BlockHeight(numSigs) + types.MaturityDelay) {
+		block, _ := cst.miner.FindBlock()
+		err = cst.cs.AcceptBlock(block)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	tp, err := txpool.New(cst.cs)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	b.StopTimer()
+	for j := 0; j < b.N; j++ {
+		for i := 0; i < numSigs; i++ {
+			txnBuilder := cst.wallet.StartTransaction()
+			err = txnBuilder.FundSiacoins(types.CalculateCoinbase(types.BlockHeight(i + 1)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			addr, _, err := cst.wallet.CoinAddress(false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			txnBuilder.AddSiacoinOutput(types.SiacoinOutput{Value: types.CalculateCoinbase(types.BlockHeight(i + 1)), UnlockHash: addr})
+			txnSet, err := txnBuilder.Sign(true)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := tp.AddLocal(txnSet[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		blk := types.Block{
+			ParentID:  cst.cs.CurrentBlock().ID(),
+			Timestamp: types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{
+				{Value: types.CalculateCoinbase(cst.cs.height())},
+			},
+			Transactions: tp.Select(types.BlockSizeLimit),
+		}
+
+		target, _ := cst.cs.ChildTarget(cst.cs.CurrentBlock().ID())
+		block, _ := cst.miner.SolveBlock(blk, target)
+		b.StartTimer()
+		err = cst.cs.AcceptBlock(block)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+
+		if err := tp.Reset(block.ParentID, block.ID()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
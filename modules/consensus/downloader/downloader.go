@@ -0,0 +1,421 @@
+// Package downloader implements a concurrent, pipelined block fetcher used
+// to catch a consensus set up to the rest of the network. It replaces the
+// serial "ask one peer, AcceptBlock, repeat" loop that initial block
+// download used previously: headers are fetched from many peers in
+// parallel to build a skeleton, bodies are filled into that skeleton from
+// a work queue keyed by height, and a single writer goroutine feeds
+// completed blocks to AcceptBlock in order.
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/consensus"
+	"github.com/NebulousLabs/Sia/modules/gateway"
+	"github.com/NebulousLabs/Sia/modules/hostdb"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// headersRPC and blockRPC are the gateway RPC names the downloader
+	// speaks to peers.
+	headersRPC = "SendHeaders"
+	blockRPC   = "SendBlock"
+
+	// bodyWorkers is the number of concurrent body-fetching workers. Each
+	// worker is bound to a single in-flight request at a time, so this is
+	// also the maximum number of peers used simultaneously.
+	bodyWorkers = 8
+
+	// requestTimeout is how long a single header or body request is given
+	// before its peer is considered unresponsive and the work is
+	// rescheduled onto another peer.
+	requestTimeout = 10 * time.Second
+
+	// writeBacklog bounds how many completed blocks may sit ahead of the
+	// writer goroutine before body workers block.
+	writeBacklog = 64
+
+	// maxJobAttempts bounds how many full rounds over the peer list a
+	// single height may fail before the sync gives up entirely.
+	maxJobAttempts = 5
+)
+
+var (
+	// ErrNoPeers is returned when a sync is started with no gateway peers
+	// to fetch from.
+	ErrNoPeers = errors.New("downloader: no peers available")
+
+	// ErrCanceled is returned by Wait when the downloader was stopped
+	// before a sync completed.
+	ErrCanceled = errors.New("downloader: sync canceled")
+)
+
+// syncInitHook is called once per Sync invocation, immediately before the
+// header skeleton is requested. Tests may replace it to observe or delay
+// the start of a sync without needing real peers.
+var syncInitHook = func() {}
+
+// job describes a single block body that still needs to be fetched.
+// attempts counts how many full rounds over the peer list have already
+// failed to deliver it, so a job can be dropped as unreachable instead of
+// rescheduled forever.
+type job struct {
+	height   types.BlockHeight
+	id       types.BlockID
+	attempts int
+}
+
+// Downloader drives a single initial-block-download sync against a
+// consensus.State. It is not reused across syncs; call New for each Sync.
+type Downloader struct {
+	cs  *consensus.State
+	g   *gateway.Gateway
+	hdb *hostdb.HostDB
+
+	mu       sync.Mutex
+	origin   types.BlockHeight
+	current  types.BlockHeight
+	highest  types.BlockHeight
+	peerBusy map[modules.NetAddress]bool
+
+	cancel chan struct{}
+	done   chan error
+
+	// fatal carries the first unrecoverable error (e.g. a height that no
+	// peer could deliver after maxJobAttempts) out of the worker pool.
+	// It's buffered so the worker that hits it never blocks on delivery.
+	fatal chan error
+}
+
+// New creates a Downloader that will sync cs against g's current peer set.
+// hdb may be nil; when provided, peers that repeatedly fail to deliver
+// valid headers or bodies are demoted in it.
+func New(cs *consensus.State, g *gateway.Gateway, hdb *hostdb.HostDB) *Downloader {
+	return &Downloader{
+		cs:       cs,
+		g:        g,
+		hdb:      hdb,
+		peerBusy: make(map[modules.NetAddress]bool),
+		cancel:   make(chan struct{}),
+		done:     make(chan error, 1),
+		fatal:    make(chan error, 1),
+	}
+}
+
+// fail records err as the sync's fatal error, if one isn't already
+// recorded, and cancels the sync. Only the first caller's error sticks.
+func (d *Downloader) fail(err error) {
+	select {
+	case d.fatal <- err:
+		d.Stop()
+	default:
+	}
+}
+
+// Progress returns the downloader's current origin/current/highest height
+// triple, matching the shape of (*consensus.State).SyncStatus so callers
+// can report progress uniformly whether or not a bulk sync is underway.
+func (d *Downloader) Progress() modules.SyncProgress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return modules.SyncProgress{
+		OriginHeight:  d.origin,
+		CurrentHeight: d.current,
+		HighestHeight: d.highest,
+	}
+}
+
+// Stop cancels an in-progress sync. It is safe to call multiple times and
+// safe to call after the sync has already finished.
+func (d *Downloader) Stop() {
+	select {
+	case <-d.cancel:
+	default:
+		close(d.cancel)
+	}
+}
+
+// Sync fetches and applies every block between the consensus set's current
+// height and the end of the header skeleton most of g's peers agree on (see
+// fetchHeaderSkeleton), then returns. It
+// blocks until the sync finishes, is canceled via Stop, or fails.
+func (d *Downloader) Sync() error {
+	syncInitHook()
+
+	peers := d.g.Peers()
+	if len(peers) == 0 {
+		return ErrNoPeers
+	}
+
+	d.mu.Lock()
+	d.origin = d.cs.Height()
+	d.current = d.origin
+	d.mu.Unlock()
+
+	skeleton, err := d.fetchHeaderSkeleton(peers)
+	if err != nil {
+		return err
+	}
+	if len(skeleton) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	d.highest = skeleton[len(skeleton)-1].height
+	d.mu.Unlock()
+
+	// jobs is sized with headroom beyond len(skeleton) so a worker
+	// rescheduling a failed fetch never blocks trying to push it back on.
+	remaining := int64(len(skeleton))
+	jobs := make(chan job, len(skeleton)+bodyWorkers)
+	for _, h := range skeleton {
+		jobs <- job{height: h.height, id: h.id}
+	}
+	var closeJobsOnce sync.Once
+	closeJobs := func() { closeJobsOnce.Do(func() { close(jobs) }) }
+
+	results := make(chan orderedBlock, writeBacklog)
+	var wg sync.WaitGroup
+	for i := 0; i < bodyWorkers; i++ {
+		wg.Add(1)
+		go d.bodyWorker(peers, jobs, results, &remaining, closeJobs, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	err = d.writeInOrder(skeleton[0].height, results)
+	select {
+	case fatalErr := <-d.fatal:
+		return fatalErr
+	default:
+	}
+	return err
+}
+
+// header is a single entry in the fetched skeleton: a block ID at a known
+// height, awaiting its body.
+type header struct {
+	height types.BlockHeight
+	id     types.BlockID
+}
+
+// fetchHeaderSkeleton requests headers from every peer in parallel, picks
+// the skeleton the most peers agree on, and demotes every peer whose
+// response doesn't match it (including peers that errored outright). This
+// stops a single lying or stale peer from dictating the sync: agreement is
+// by popular vote rather than by whichever response happens to be longest.
+func (d *Downloader) fetchHeaderSkeleton(peers []modules.NetAddress) ([]header, error) {
+	type result struct {
+		peer    modules.NetAddress
+		headers []header
+		err     error
+	}
+	resultsCh := make(chan result, len(peers))
+
+	startHeight := d.cs.Height()
+	for _, peer := range peers {
+		peer := peer
+		go func() {
+			var ids []types.BlockID
+			err := d.g.RPC(peer, headersRPC, func(conn modules.PeerConn) error {
+				conn.SetDeadline(time.Now().Add(requestTimeout))
+				if err := encoding.WriteObject(conn, startHeight); err != nil {
+					return err
+				}
+				return encoding.ReadObject(conn, &ids, encoding.DefaultAllocLimit)
+			})
+			if err != nil {
+				resultsCh <- result{peer: peer, err: err}
+				return
+			}
+			headers := make([]header, len(ids))
+			for i, id := range ids {
+				headers[i] = header{height: startHeight + types.BlockHeight(i) + 1, id: id}
+			}
+			resultsCh <- result{peer: peer, headers: headers}
+		}()
+	}
+
+	var collected []result
+	for range peers {
+		select {
+		case r := <-resultsCh:
+			collected = append(collected, r)
+		case <-d.cancel:
+			return nil, ErrCanceled
+		}
+	}
+
+	// Group successful responses by their exact header sequence, and pick
+	// the sequence the most peers agree on (ties favor the longer chain).
+	counts := make(map[string]int)
+	bySignature := make(map[string][]header)
+	for _, r := range collected {
+		if r.err != nil {
+			continue
+		}
+		sig := headerSignature(r.headers)
+		counts[sig]++
+		bySignature[sig] = r.headers
+	}
+	var bestSig string
+	for sig, count := range counts {
+		if count > counts[bestSig] || (count == counts[bestSig] && len(bySignature[sig]) > len(bySignature[bestSig])) {
+			bestSig = sig
+		}
+	}
+
+	// Demote every peer that errored or whose headers disagreed with the
+	// winning skeleton.
+	if d.hdb != nil {
+		for _, r := range collected {
+			if r.err != nil || headerSignature(r.headers) != bestSig {
+				d.hdb.DemotePeer(r.peer)
+			}
+		}
+	}
+
+	return bySignature[bestSig], nil
+}
+
+// headerSignature returns a value comparable with == that uniquely
+// identifies a header sequence, so fetchHeaderSkeleton can tally how many
+// peers returned the same chain without comparing slices element-by-element.
+func headerSignature(headers []header) string {
+	var b strings.Builder
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%d:%x;", h.height, h.id)
+	}
+	return b.String()
+}
+
+// orderedBlock pairs a fetched block with the height it belongs at, so the
+// writer goroutine can reassemble the stream in order even though bodies
+// arrive from workers out of order.
+type orderedBlock struct {
+	height types.BlockHeight
+	block  types.Block
+}
+
+// bodyWorker pulls jobs off the queue and fetches the corresponding block
+// body from a peer. A job that fails against every peer it tries this round
+// is pushed back onto jobs for another worker to pick up later, up to
+// maxJobAttempts total attempts, at which point the sync is aborted as
+// unreachable. Every failed fetch demotes the offending peer in hdb, if one
+// was configured.
+func (d *Downloader) bodyWorker(peers []modules.NetAddress, jobs chan job, results chan<- orderedBlock, remaining *int64, closeJobs func(), wg *sync.WaitGroup) {
+	defer wg.Done()
+	peerIdx := 0
+	for j := range jobs {
+		select {
+		case <-d.cancel:
+			return
+		default:
+		}
+
+		fetched := false
+		for attempt := 0; attempt < len(peers); attempt++ {
+			peer := peers[peerIdx%len(peers)]
+			peerIdx++
+
+			var block types.Block
+			err := d.g.RPC(peer, blockRPC, func(conn modules.PeerConn) error {
+				conn.SetDeadline(time.Now().Add(requestTimeout))
+				if err := encoding.WriteObject(conn, j.id); err != nil {
+					return err
+				}
+				return encoding.ReadObject(conn, &block, encoding.DefaultAllocLimit)
+			})
+			if err != nil {
+				if d.hdb != nil {
+					d.hdb.DemotePeer(peer)
+				}
+				continue
+			}
+
+			results <- orderedBlock{height: j.height, block: block}
+			fetched = true
+			break
+		}
+
+		if fetched {
+			if atomic.AddInt64(remaining, -1) == 0 {
+				closeJobs()
+			}
+			continue
+		}
+
+		j.attempts++
+		if j.attempts >= maxJobAttempts {
+			d.fail(fmt.Errorf("downloader: could not fetch block at height %d from any peer after %d attempts", j.height, j.attempts))
+			return
+		}
+		select {
+		case jobs <- j:
+		case <-d.cancel:
+			return
+		}
+	}
+}
+
+// writeInOrder reads fetched blocks off results, buffers any that arrive
+// ahead of the next expected height, and hands them to AcceptBlock strictly
+// in order. This is the only goroutine that calls AcceptBlock, so consensus
+// set mutation during a sync stays single-threaded.
+func (d *Downloader) writeInOrder(nextHeight types.BlockHeight, results <-chan orderedBlock) error {
+	pending := make(map[types.BlockHeight]types.Block)
+	for {
+		select {
+		case ob, ok := <-results:
+			if !ok {
+				return d.flush(nextHeight, pending)
+			}
+			pending[ob.height] = ob.block
+			for {
+				block, ok := pending[nextHeight]
+				if !ok {
+					break
+				}
+				if err := d.cs.AcceptBlock(block); err != nil {
+					return err
+				}
+				d.mu.Lock()
+				d.current = nextHeight
+				d.mu.Unlock()
+				delete(pending, nextHeight)
+				nextHeight++
+			}
+		case <-d.cancel:
+			return ErrCanceled
+		}
+	}
+}
+
+// flush applies any remaining in-order blocks once the results channel has
+// closed, in case the final arrivals completed the sequence.
+func (d *Downloader) flush(nextHeight types.BlockHeight, pending map[types.BlockHeight]types.Block) error {
+	for {
+		block, ok := pending[nextHeight]
+		if !ok {
+			return nil
+		}
+		if err := d.cs.AcceptBlock(block); err != nil {
+			return err
+		}
+		d.mu.Lock()
+		d.current = nextHeight
+		d.mu.Unlock()
+		delete(pending, nextHeight)
+		nextHeight++
+	}
+}
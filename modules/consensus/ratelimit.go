@@ -0,0 +1,39 @@
+package consensus
+
+import (
+	"time"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// gossipQPS and gossipBurst bound how fast gossip-relayed blocks are
+// validated. A peer flooding equivalent-height blocks previously walked the
+// full validation pipeline once per block; the limiter caps that to a
+// steady rate and folds duplicates (by block ID) within the window into a
+// single validation.
+const (
+	gossipQPS    = 20
+	gossipBurst  = 40
+	gossipWindow = 30 * time.Second
+)
+
+// AcceptGossipBlock rate-limits and deduplicates blocks arriving from
+// gossip before handing them to AcceptBlock, so a peer re-announcing the
+// same or equivalent-height blocks can't force repeated full validation
+// passes. Locally produced or directly-requested blocks should still go
+// through AcceptBlock directly.
+func (s *State) AcceptGossipBlock(block types.Block) error {
+	s.mu.Lock()
+	if s.gossipLimiter == nil {
+		s.gossipLimiter = modules.NewSubmissionRateLimiter(gossipQPS, gossipBurst, gossipWindow)
+		s.gossipLimiter.Start()
+	}
+	limiter := s.gossipLimiter
+	s.mu.Unlock()
+
+	return limiter.Submit(encoding.Marshal(block.ID()), func() error {
+		return s.AcceptBlock(block)
+	})
+}
@@ -0,0 +1,101 @@
+package consensus
+
+import (
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// syncProgress tracks the state needed to report initial-block-download
+// progress and to feed the SyncProgress event stream. It is guarded by the
+// State's own mu, the same lock used for every other mutable field on
+// State.
+type syncProgress struct {
+	origin      types.BlockHeight
+	current     types.BlockHeight
+	highest     types.BlockHeight
+	lastUpdate  time.Time
+	lastAdvance time.Time
+	subscribers []chan modules.SyncProgress
+}
+
+// SetPeerHeight records the highest block height any connected peer has
+// announced. The consensus set uses this as the denominator when reporting
+// SyncStatus, so gateway/peer-manager code should call it whenever a peer's
+// claimed height changes.
+func (s *State) SetPeerHeight(height types.BlockHeight) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height > s.sync.highest {
+		s.sync.highest = height
+	}
+}
+
+// SyncStatus returns a snapshot of the consensus set's initial-block-download
+// progress: the height sync started from, the height processed so far, the
+// highest height known from any peer, and the blocks-per-second rate
+// computed since the last recorded advance. Callers poll this instead of
+// diffing CurrentBlock heights by hand.
+func (s *State) SyncStatus() modules.SyncProgress {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rate := 0.0
+	if elapsed := time.Since(s.sync.lastAdvance); elapsed > 0 {
+		rate = 1 / elapsed.Seconds()
+	}
+
+	return modules.SyncProgress{
+		OriginHeight:  s.sync.origin,
+		CurrentHeight: s.sync.current,
+		HighestHeight: s.sync.highest,
+		BlocksPerSec:  rate,
+	}
+}
+
+// SubscribeSyncProgress returns a channel that receives a SyncProgress event
+// every time AcceptBlock advances the current height. The channel is
+// buffered so a slow reader can't stall block acceptance; if it fills, the
+// oldest unread update is simply overwritten by the newest one.
+func (s *State) SubscribeSyncProgress() <-chan modules.SyncProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := make(chan modules.SyncProgress, 1)
+	s.sync.subscribers = append(s.sync.subscribers, c)
+	return c
+}
+
+// advanceSyncProgress is called by AcceptBlock whenever the tip height
+// increases. It updates the rate counters and notifies subscribers. Callers
+// must hold s.mu for writing.
+func (s *State) advanceSyncProgress(height types.BlockHeight) {
+	if s.sync.lastAdvance.IsZero() {
+		s.sync.origin = s.sync.current
+	}
+	s.sync.current = height
+	if height > s.sync.highest {
+		s.sync.highest = height
+	}
+	s.sync.lastAdvance = time.Now()
+
+	update := modules.SyncProgress{
+		OriginHeight:  s.sync.origin,
+		CurrentHeight: s.sync.current,
+		HighestHeight: s.sync.highest,
+	}
+	for _, c := range s.sync.subscribers {
+		select {
+		case c <- update:
+		default:
+			// drain the stale update and push the fresh one so subscribers
+			// never block AcceptBlock on a full channel.
+			select {
+			case <-c:
+			default:
+			}
+			c <- update
+		}
+	}
+}
@@ -3,6 +3,7 @@ package hostdb
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/NebulousLabs/Sia/consensus"
 	"github.com/NebulousLabs/Sia/modules"
@@ -24,9 +25,61 @@ type HostDB struct {
 	activeHosts map[string]*hostNode
 	allHosts    map[network.Address]*modules.HostEntry
 
+	// stats holds the rolling, EWMA-smoothed interaction history used by
+	// score() to compute each host's composite weight. It's keyed the same
+	// way as activeHosts.
+	stats map[string]*hostStats
+
+	// subscribers are notified whenever a host is added, removed, or its
+	// score changes enough to move it in the tree.
+	subscribers []chan HostSetChange
+
+	// probeCancel stops the background prober started by StartProbing.
+	probeCancel chan struct{}
+
+	// peerPenalties holds a persistent score multiplier per address, set by
+	// DemotePeer. It's kept separate from stats because stats are folded
+	// into a host's weight by score() on every interaction; a penalty
+	// applied directly to node.weight would be overwritten by the very next
+	// recordInteraction/recordContractFailure/recordPriceDrift call. score()
+	// applies peerPenalties on every recompute instead, so the penalty
+	// survives. Keyed the same way as activeHosts and stats, so a peer that
+	// misbehaves during sync and also happens to be a contracted host gets
+	// penalized consistently across both roles.
+	peerPenalties map[string]float64
+
 	mu sync.RWMutex
 }
 
+// DemotePeer penalizes a peer identified by addr for misbehaving during
+// block sync (e.g. delivering headers or bodies that don't check out). It
+// is a lightweight hook for callers like the consensus downloader that
+// don't otherwise interact with the host tree. Sync peers (modules.NetAddress)
+// and contracted hosts (network.Address) are different address spaces, so
+// addr usually won't match any entry in activeHosts; the penalty is still
+// recorded in peerPenalties so it takes effect if addr is, or later becomes,
+// a contracted host.
+func (hdb *HostDB) DemotePeer(addr modules.NetAddress) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+
+	penalty, exists := hdb.peerPenalties[string(addr)]
+	if !exists {
+		penalty = 1
+	}
+	penalty *= 0.5
+	if penalty < 0.01 {
+		penalty = 0.01
+	}
+	hdb.peerPenalties[string(addr)] = penalty
+
+	node, exists := hdb.activeHosts[string(addr)]
+	if !exists {
+		return
+	}
+	node.weight = hdb.score(node, hdb.stats[string(addr)])
+}
+
 // New returns an empty HostDatabase.
 func New(s *consensus.State) (hdb *HostDB, err error) {
 	if s == nil {
@@ -44,10 +97,36 @@ func New(s *consensus.State) (hdb *HostDB, err error) {
 	}
 
 	hdb = &HostDB{
-		state:       s,
-		recentBlock: genesisBlock.ID(),
-		activeHosts: make(map[string]*hostNode),
-		allHosts:    make(map[network.Address]*modules.HostEntry),
+		state:         s,
+		recentBlock:   genesisBlock.ID(),
+		activeHosts:   make(map[string]*hostNode),
+		allHosts:      make(map[network.Address]*modules.HostEntry),
+		stats:         make(map[string]*hostStats),
+		peerPenalties: make(map[string]float64),
 	}
 	return
 }
+
+// recordInteraction updates a host's rolling statistics with the outcome of
+// a single renter-host interaction (a contract negotiation, a download, a
+// probe) and recomputes the host's composite weight in the tree. latency is
+// the round-trip time of the interaction and bytesPerSec its measured
+// transfer rate; either may be zero if not applicable (e.g. a failed dial).
+func (hdb *HostDB) recordInteraction(addr network.Address, latency time.Duration, bytesPerSec float64, success bool) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+
+	s, exists := hdb.stats[string(addr)]
+	if !exists {
+		s = newHostStats()
+		hdb.stats[string(addr)] = s
+	}
+	s.update(latency, bytesPerSec, success)
+
+	node, exists := hdb.activeHosts[string(addr)]
+	if !exists {
+		return
+	}
+	node.weight = hdb.score(node, s)
+	hdb.notify(HostSetChange{Updated: []network.Address{addr}})
+}
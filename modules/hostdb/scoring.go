@@ -0,0 +1,318 @@
+package hostdb
+
+import (
+	"time"
+
+	"github.com/NebulousLabs/Sia/fastrand"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// statDecay is the EWMA smoothing factor applied to every rolling
+	// statistic on each update: newAvg = decay*sample + (1-decay)*oldAvg.
+	// A low decay favors long-run reputation over a single bad sample.
+	statDecay = 0.1
+
+	// probeInterval is the base period between active pings of a host; the
+	// actual delay is jittered by +/- probeJitter to avoid synchronizing
+	// every host's probe across the network.
+	probeInterval = 15 * time.Minute
+	probeJitter   = 5 * time.Minute
+
+	// probeTimeout bounds how long a single probe RPC may take before the
+	// host is counted as unreachable for that round.
+	probeTimeout = 10 * time.Second
+)
+
+// hostStats holds the rolling, EWMA-smoothed interaction history for a
+// single host. It replaces the old model of assigning a host's weight once
+// at insertion time: every interaction nudges these averages, and the
+// host's tree weight is recomputed from them.
+type hostStats struct {
+	latency             float64 // seconds, EWMA
+	bandwidth           float64 // bytes/sec, EWMA
+	uptime              float64 // fraction of successful interactions, EWMA
+	contractFailureRate float64 // fraction of failed contract negotiations, EWMA
+	priceDrift          float64 // fractional deviation from the host's announced settlement price, EWMA
+
+	samples int
+}
+
+// newHostStats returns a hostStats seeded with full uptime and no latency,
+// bandwidth, failure or drift history, so a new host's first samples pull
+// it down from an optimistic starting point.
+func newHostStats() *hostStats {
+	return &hostStats{
+		uptime: 1,
+	}
+}
+
+// update folds one interaction's outcome into the rolling averages.
+func (s *hostStats) update(latency time.Duration, bytesPerSec float64, success bool) {
+	ewma := func(old, sample float64) float64 {
+		return statDecay*sample + (1-statDecay)*old
+	}
+
+	if latency > 0 {
+		s.latency = ewma(s.latency, latency.Seconds())
+	}
+	if bytesPerSec > 0 {
+		s.bandwidth = ewma(s.bandwidth, bytesPerSec)
+	}
+	uptimeSample := 0.0
+	if success {
+		uptimeSample = 1.0
+	}
+	s.uptime = ewma(s.uptime, uptimeSample)
+	s.samples++
+}
+
+// recordContractFailure folds a contract negotiation failure into the
+// host's rolling failure rate. It's tracked separately from general
+// interaction uptime because a host can be reachable and fast but still
+// unreliable about honoring contracts.
+func (hdb *HostDB) recordContractFailure(addr network.Address, failed bool) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+
+	s, exists := hdb.stats[string(addr)]
+	if !exists {
+		s = newHostStats()
+		hdb.stats[string(addr)] = s
+	}
+	sample := 0.0
+	if failed {
+		sample = 1.0
+	}
+	s.contractFailureRate = statDecay*sample + (1-statDecay)*s.contractFailureRate
+
+	if node, ok := hdb.activeHosts[string(addr)]; ok {
+		node.weight = hdb.score(node, s)
+	}
+}
+
+// recordPriceDrift folds the fractional difference between a host's
+// announced settlement price and the price it actually charged into its
+// rolling drift average.
+func (hdb *HostDB) recordPriceDrift(addr network.Address, fractionalDrift float64) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+
+	s, exists := hdb.stats[string(addr)]
+	if !exists {
+		s = newHostStats()
+		hdb.stats[string(addr)] = s
+	}
+	s.priceDrift = statDecay*fractionalDrift + (1-statDecay)*s.priceDrift
+
+	if node, ok := hdb.activeHosts[string(addr)]; ok {
+		node.weight = hdb.score(node, s)
+	}
+}
+
+// score combines a host's base collateral/price weight with its rolling
+// reputation stats into the composite weight used for selection. Hosts
+// with no recorded interactions yet fall back to the base weight alone so
+// new hosts aren't penalized before they've had a chance to prove
+// themselves.
+func (hdb *HostDB) score(node *hostNode, s *hostStats) types.Currency {
+	base := node.baseWeight()
+	if penalty, ok := hdb.peerPenalties[string(node.entry.IPAddress)]; ok {
+		base = base.MulFloat(penalty)
+	}
+	if s == nil || s.samples == 0 {
+		return base
+	}
+
+	// Each factor is a multiplier centered on 1; penalties push it toward
+	// 0, nothing pushes it above 1. Latency and bandwidth are normalized
+	// against rough expectations rather than other hosts, so scores stay
+	// stable as the host set changes.
+	latencyFactor := 1.0
+	if s.latency > 0.25 {
+		latencyFactor = 0.25 / s.latency
+	}
+	bandwidthFactor := 1.0
+	if s.bandwidth > 0 && s.bandwidth < 1<<20 {
+		bandwidthFactor = s.bandwidth / (1 << 20)
+	}
+	uptimeFactor := s.uptime
+	failureFactor := 1 - s.contractFailureRate
+	driftFactor := 1.0
+	if s.priceDrift > 0 {
+		driftFactor = 1 / (1 + s.priceDrift)
+	}
+
+	composite := latencyFactor * bandwidthFactor * uptimeFactor * failureFactor * driftFactor
+	if composite < 0.01 {
+		composite = 0.01
+	}
+	return base.MulFloat(composite)
+}
+
+// HostFilter narrows the hosts SelectHosts is willing to return, e.g. to
+// exclude hosts already under contract or hosts that don't meet a minimum
+// storage requirement.
+type HostFilter func(modules.HostEntry) bool
+
+// SelectHosts samples up to n distinct hosts from the tree, weighted by
+// each host's composite score, skipping any host that filter rejects. It
+// may return fewer than n entries if the filtered pool is smaller than n.
+func (hdb *HostDB) SelectHosts(n int, filter HostFilter) []modules.HostEntry {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+
+	var selected []modules.HostEntry
+	excluded := make([]*hostNode, 0, n)
+
+	for len(selected) < n {
+		total := hdb.hostTree.weight()
+		if total.IsZero() {
+			break
+		}
+		node, err := hdb.hostTree.nodeAtWeight(randomCurrency(total))
+		if err != nil {
+			break
+		}
+		hdb.hostTree.remove(node)
+		excluded = append(excluded, node)
+
+		if filter == nil || filter(*node.entry) {
+			selected = append(selected, *node.entry)
+		}
+	}
+
+	// Incrementally re-insert the nodes we pulled out to sample, rather
+	// than rebuilding the whole tree from scratch.
+	for _, node := range excluded {
+		hdb.hostTree.insert(node)
+	}
+
+	return selected
+}
+
+// randomCurrency returns a uniformly random value in [0, max).
+func randomCurrency(max types.Currency) types.Currency {
+	if max.IsZero() {
+		return max
+	}
+	return types.NewCurrency(fastrand.BigIntn(max.Big()))
+}
+
+// HostSetChange describes hosts that were added, removed, or had their
+// score updated since the last notification. Consumers like the renter use
+// this to react to hosts going offline without polling ActiveHosts.
+type HostSetChange struct {
+	Added   []network.Address
+	Removed []network.Address
+	Updated []network.Address
+}
+
+// Subscribe returns a channel on which HostSetChange notifications are
+// delivered. The channel buffers 8 changes; once it is full, further
+// changes of any kind (including removals) are dropped for that subscriber
+// until it reads.
+func (hdb *HostDB) Subscribe() <-chan HostSetChange {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+
+	c := make(chan HostSetChange, 8)
+	hdb.subscribers = append(hdb.subscribers, c)
+	return c
+}
+
+// notify delivers change to every subscriber. Callers must hold hdb.mu.
+func (hdb *HostDB) notify(change HostSetChange) {
+	for _, c := range hdb.subscribers {
+		select {
+		case c <- change:
+		default:
+			// subscriber is behind; drop the update rather than blocking
+			// the caller (which may be holding hdb.mu).
+		}
+	}
+}
+
+// StartProbing launches the background prober, which pings each active
+// host on a jittered schedule and folds the result into its uptime and
+// latency stats. Call Stop to halt it.
+func (hdb *HostDB) StartProbing() {
+	hdb.mu.Lock()
+	if hdb.probeCancel != nil {
+		hdb.mu.Unlock()
+		return
+	}
+	cancel := make(chan struct{})
+	hdb.probeCancel = cancel
+	hdb.mu.Unlock()
+
+	go hdb.probeLoop(cancel)
+}
+
+// Stop halts the background prober started by StartProbing. It is safe to
+// call even if probing was never started.
+func (hdb *HostDB) Stop() {
+	hdb.mu.Lock()
+	cancel := hdb.probeCancel
+	hdb.probeCancel = nil
+	hdb.mu.Unlock()
+
+	if cancel != nil {
+		close(cancel)
+	}
+}
+
+// probeLoop repeatedly pings every active host, jittering both the initial
+// delay and each subsequent interval so that, across many renters, probes
+// don't all land on a host at once.
+func (hdb *HostDB) probeLoop(cancel chan struct{}) {
+	for {
+		jitter := time.Duration(fastrand.Intn(int(2 * probeJitter)))
+		wait := probeInterval - probeJitter + jitter
+
+		select {
+		case <-time.After(wait):
+		case <-cancel:
+			return
+		}
+
+		for _, addr := range hdb.activeAddresses() {
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+			hdb.probe(addr)
+		}
+	}
+}
+
+// activeAddresses returns a snapshot of the currently active host
+// addresses, so probeLoop doesn't hold hdb.mu for the duration of a full
+// probing pass.
+func (hdb *HostDB) activeAddresses() []network.Address {
+	hdb.mu.RLock()
+	defer hdb.mu.RUnlock()
+
+	addrs := make([]network.Address, 0, len(hdb.activeHosts))
+	for _, node := range hdb.activeHosts {
+		addrs = append(addrs, node.entry.IPAddress)
+	}
+	return addrs
+}
+
+// probe performs a single cheap RPC ping against addr and records the
+// outcome. A failed or timed-out probe counts as a downtime sample; a
+// successful one records the measured latency.
+func (hdb *HostDB) probe(addr network.Address) {
+	start := time.Now()
+	err := addr.Ping(probeTimeout)
+	latency := time.Since(start)
+	if err != nil {
+		hdb.recordInteraction(addr, 0, 0, false)
+		return
+	}
+	hdb.recordInteraction(addr, latency, 0, true)
+}
@@ -0,0 +1,186 @@
+package modules
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SubmissionRateLimiter serializes a stream of candidate submissions
+// (gossip-relayed blocks, renter-to-host chunk pushes) through a bounded
+// channel so a flood of equivalent-height blocks or parallel uploads can't
+// force every candidate through the full validation/submission pipeline at
+// once. Duplicate submissions (by content hash) within the dedup window are
+// rejected without ever reaching the channel.
+type SubmissionRateLimiter struct {
+	qps   float64
+	burst int
+
+	mu       sync.Mutex
+	tokens   float64
+	lastFill time.Time
+	seen     map[[32]byte]time.Time
+	window   time.Duration
+
+	queue chan submission
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// submission pairs a candidate's content hash and processing function with
+// the channel its caller is blocked on.
+type submission struct {
+	hash    [32]byte
+	process func() error
+	result  chan error
+}
+
+// ErrDuplicateSubmission is returned when a submission's content hash was
+// already seen within the dedup window.
+var ErrDuplicateSubmission = errors.New("modules: duplicate submission within dedup window")
+
+// NewSubmissionRateLimiter returns a limiter allowing qps submissions per
+// second on average, with bursts up to burst candidates queued at once.
+// window controls how long a content hash is remembered for deduplication.
+func NewSubmissionRateLimiter(qps float64, burst int, window time.Duration) *SubmissionRateLimiter {
+	return &SubmissionRateLimiter{
+		qps:      qps,
+		burst:    burst,
+		tokens:   float64(burst),
+		lastFill: time.Now(),
+		seen:     make(map[[32]byte]time.Time),
+		window:   window,
+		queue:    make(chan submission, burst),
+	}
+}
+
+// Start launches the limiter's single worker goroutine, which pulls
+// submissions off the queue at the configured rate and runs their process
+// function. Start is a no-op if the limiter is already running.
+func (rl *SubmissionRateLimiter) Start() {
+	rl.mu.Lock()
+	if rl.done != nil {
+		rl.mu.Unlock()
+		return
+	}
+	rl.done = make(chan struct{})
+	done := rl.done
+	rl.mu.Unlock()
+
+	rl.wg.Add(1)
+	go rl.run(done)
+}
+
+// Stop halts the worker goroutine. Any submissions already queued are
+// dropped and their callers receive ErrStopped. Stop blocks until the
+// worker has exited.
+func (rl *SubmissionRateLimiter) Stop() {
+	rl.mu.Lock()
+	done := rl.done
+	rl.done = nil
+	rl.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+	close(done)
+	rl.wg.Wait()
+}
+
+// ErrStopped is returned to any submission still queued when Stop is
+// called.
+var ErrStopped = errors.New("modules: rate limiter stopped")
+
+// run is the worker loop: drain the queue at the configured rate, applying
+// the token bucket before each submission.
+func (rl *SubmissionRateLimiter) run(done chan struct{}) {
+	defer rl.wg.Done()
+	for {
+		select {
+		case s := <-rl.queue:
+			rl.wait(done)
+			select {
+			case <-done:
+				s.result <- ErrStopped
+			default:
+				s.result <- s.process()
+			}
+		case <-done:
+			rl.drain(done)
+			return
+		}
+	}
+}
+
+// drain fails out anything left in the queue once Stop has been called.
+func (rl *SubmissionRateLimiter) drain(done chan struct{}) {
+	for {
+		select {
+		case s := <-rl.queue:
+			s.result <- ErrStopped
+		default:
+			return
+		}
+	}
+}
+
+// wait blocks until a token is available, refilling the bucket based on
+// elapsed time since the last fill.
+func (rl *SubmissionRateLimiter) wait(done chan struct{}) {
+	for {
+		rl.mu.Lock()
+		now := time.Now()
+		elapsed := now.Sub(rl.lastFill).Seconds()
+		rl.tokens += elapsed * rl.qps
+		if rl.tokens > float64(rl.burst) {
+			rl.tokens = float64(rl.burst)
+		}
+		rl.lastFill = now
+		if rl.tokens >= 1 {
+			rl.tokens--
+			rl.mu.Unlock()
+			return
+		}
+		rl.mu.Unlock()
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-done:
+			return
+		}
+	}
+}
+
+// Submit enqueues data for rate-limited processing via process, and blocks
+// until process has run (or the submission is rejected as a duplicate, or
+// the limiter is stopped). data is hashed to detect duplicates; callers
+// that already have a stable content hash should hash that instead of a
+// serialized form to avoid re-hashing large payloads. If the limiter isn't
+// running (never started, or stopped), duplicates are still rejected but
+// process runs immediately on the caller's goroutine, without pacing.
+func (rl *SubmissionRateLimiter) Submit(data []byte, process func() error) error {
+	hash := sha256.Sum256(data)
+
+	rl.mu.Lock()
+	if last, ok := rl.seen[hash]; ok && time.Since(last) < rl.window {
+		rl.mu.Unlock()
+		return ErrDuplicateSubmission
+	}
+	rl.seen[hash] = time.Now()
+	for h, t := range rl.seen {
+		if time.Since(t) > rl.window {
+			delete(rl.seen, h)
+		}
+	}
+	running := rl.done != nil
+	rl.mu.Unlock()
+
+	if !running {
+		return process()
+	}
+
+	s := submission{hash: hash, process: process, result: make(chan error, 1)}
+	rl.queue <- s
+	return <-s.result
+}
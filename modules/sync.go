@@ -0,0 +1,16 @@
+package modules
+
+import "github.com/NebulousLabs/Sia/types"
+
+// SyncProgress reports initial-block-download progress as an
+// origin/current/highest height triple: the height a sync started from,
+// the height processed so far, and the highest height known from any peer.
+// BlocksPerSec is the rate computed since the last recorded advance.
+// consensus.State.SyncStatus and the downloader's Progress both return
+// this shape so callers can render a sync bar uniformly.
+type SyncProgress struct {
+	OriginHeight  types.BlockHeight
+	CurrentHeight types.BlockHeight
+	HighestHeight types.BlockHeight
+	BlocksPerSec  float64
+}
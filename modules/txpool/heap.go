@@ -0,0 +1,148 @@
+package txpool
+
+import (
+	"container/heap"
+
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// feeItem is a single entry in the fee-priority heap: a transaction plus
+// its precomputed fee-per-byte, so the heap doesn't need to recompute it
+// on every comparison.
+type feeItem struct {
+	txn        types.Transaction
+	feePerByte types.Currency
+	index      int
+}
+
+// feeHeap is a max-heap of pending transactions ordered by fee-per-byte,
+// used by the miner to greedily fill a block with the most valuable
+// transactions first. It implements container/heap.Interface.
+type feeHeap []*feeItem
+
+func (h feeHeap) Len() int { return len(h) }
+
+func (h feeHeap) Less(i, j int) bool {
+	// Higher fee-per-byte sorts first.
+	return h[i].feePerByte.Cmp(h[j].feePerByte) > 0
+}
+
+func (h feeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *feeHeap) Push(x interface{}) {
+	item := x.(*feeItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *feeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// newFeeItem builds a feeItem for txn, computing its fee-per-byte from the
+// sum of its miner fees divided by its encoded size.
+func newFeeItem(txn types.Transaction) *feeItem {
+	size := len(encodeTransaction(txn))
+	total := types.ZeroCurrency
+	for _, fee := range txn.MinerFees {
+		total = total.Add(fee)
+	}
+	perByte := types.ZeroCurrency
+	if size > 0 {
+		perByte = total.Div(types.NewCurrency64(uint64(size)))
+	}
+	return &feeItem{txn: txn, feePerByte: perByte}
+}
+
+// pendingHeap wraps feeHeap with a lookup index so items can be removed by
+// transaction ID (e.g. once a transaction is mined or evicted) without a
+// linear scan.
+type pendingHeap struct {
+	h      feeHeap
+	lookup map[types.TransactionID]*feeItem
+}
+
+func newPendingHeap() *pendingHeap {
+	return &pendingHeap{
+		h:      feeHeap{},
+		lookup: make(map[types.TransactionID]*feeItem),
+	}
+}
+
+// insert adds txn to the heap. If txn is already present, it's a no-op.
+func (p *pendingHeap) insert(txn types.Transaction) {
+	id := txn.ID()
+	if _, exists := p.lookup[id]; exists {
+		return
+	}
+	item := newFeeItem(txn)
+	p.lookup[id] = item
+	heap.Push(&p.h, item)
+}
+
+// remove drops txn from the heap by ID, if present.
+func (p *pendingHeap) remove(id types.TransactionID) {
+	item, exists := p.lookup[id]
+	if !exists {
+		return
+	}
+	heap.Remove(&p.h, item.index)
+	delete(p.lookup, id)
+}
+
+// lowestFee returns the transaction with the smallest fee-per-byte
+// currently in the heap, used to pick an eviction candidate when the pool
+// is full. The second return is false if the heap is empty.
+func (p *pendingHeap) lowestFee() (types.Transaction, bool) {
+	if len(p.h) == 0 {
+		return types.Transaction{}, false
+	}
+	lowest := p.h[0]
+	for _, item := range p.h {
+		if item.feePerByte.Cmp(lowest.feePerByte) < 0 {
+			lowest = item
+		}
+	}
+	return lowest.txn, true
+}
+
+// selectTop returns up to maxBytes worth of transactions, highest
+// fee-per-byte first, without mutating the heap. It's used by the miner to
+// build a block.
+func (p *pendingHeap) selectTop(maxBytes uint64) []types.Transaction {
+	items := make(feeHeap, len(p.h))
+	copy(items, p.h)
+	sorted := make(feeHeap, 0, len(items))
+	tmp := make(feeHeap, len(items))
+	copy(tmp, items)
+	heap.Init(&tmp)
+	for tmp.Len() > 0 {
+		sorted = append(sorted, heap.Pop(&tmp).(*feeItem))
+	}
+
+	var selected []types.Transaction
+	var used uint64
+	for _, item := range sorted {
+		size := uint64(len(encodeTransaction(item.txn)))
+		if used+size > maxBytes {
+			continue
+		}
+		selected = append(selected, item.txn)
+		used += size
+	}
+	return selected
+}
+
+func (p *pendingHeap) len() int {
+	return len(p.h)
+}
@@ -0,0 +1,383 @@
+// Package txpool implements a shared transaction pool that the miner and
+// consensus set can consult, replacing the old pattern of constructing
+// transactions inline and pushing them directly into a block (see
+// BenchmarkAcceptBigTxBlocks in modules/consensus).
+//
+// Transactions are split into two tiers: pending transactions are
+// executable right now (their inputs exist and their signatures, nonces,
+// and parents all check out against the current consensus set) and are
+// indexed by fee-per-byte for miner selection; queued transactions depend
+// on an output produced by another transaction that is itself only queued
+// or pending, and are promoted to pending once that dependency lands.
+package txpool
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules/consensus"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// maxPoolSize bounds the combined number of pending and queued
+	// transactions. Once full, AddRemote evicts a lower fee-per-byte
+	// pending transaction to make room for a higher-paying one; AddLocal
+	// is exempt from this limit.
+	maxPoolSize = 50000
+)
+
+// minFeePerByte is the fee-floor AddRemote enforces on every submission,
+// regardless of how full the pool is. AddLocal transactions are exempt. A
+// composite literal like types.Currency{} isn't a valid const expression,
+// so this has to be a var.
+var minFeePerByte = types.NewCurrency64(1)
+
+var (
+	// ErrPoolFull is returned by AddRemote when the pool has no room and
+	// txn doesn't out-bid any evictable pending transaction.
+	ErrPoolFull = errors.New("txpool: full, and transaction's fee doesn't outbid an evictable pending transaction")
+
+	// ErrBelowFeeFloor is returned by AddRemote when txn's fee-per-byte is
+	// below minFeePerByte.
+	ErrBelowFeeFloor = errors.New("txpool: transaction's fee-per-byte is below the pool's fee floor")
+
+	// ErrDuplicateTransaction is returned when a transaction already
+	// present (in either tier) is submitted again.
+	ErrDuplicateTransaction = errors.New("txpool: transaction is already known")
+
+	// ErrInvalidTransaction is returned when a transaction fails basic
+	// validation (bad signature, missing input, etc.) and doesn't qualify
+	// for either tier.
+	ErrInvalidTransaction = errors.New("txpool: transaction is invalid")
+)
+
+// dependency describes why a transaction is stuck in the queued tier: it
+// spends outputID, which isn't yet available in the consensus set.
+type dependency struct {
+	outputID types.SiacoinOutputID
+}
+
+// TransactionPool holds every transaction this node knows about that isn't
+// yet confirmed in a block, split into the pending and queued tiers
+// described in the package doc.
+type TransactionPool struct {
+	cs *consensus.State
+
+	mu sync.Mutex
+
+	pending *pendingHeap
+
+	queued     map[types.TransactionID]types.Transaction
+	queuedDeps map[types.TransactionID]dependency
+
+	// bySender indexes both tiers by the unlock hash of each transaction's
+	// first signed input, so AddRemote can evict an existing low-priority
+	// transaction from the same sender instead of a stranger's. senderOf
+	// is the reverse lookup, so a transaction can be pruned from bySender
+	// by ID alone wherever it leaves the pool.
+	bySender map[types.UnlockHash][]types.TransactionID
+	senderOf map[types.TransactionID]types.UnlockHash
+
+	subscribers []chan []types.Transaction
+}
+
+// New returns an empty TransactionPool tracking cs.
+func New(cs *consensus.State) (*TransactionPool, error) {
+	if cs == nil {
+		return nil, errors.New("txpool: cannot use a nil consensus set")
+	}
+	return &TransactionPool{
+		cs:         cs,
+		pending:    newPendingHeap(),
+		queued:     make(map[types.TransactionID]types.Transaction),
+		queuedDeps: make(map[types.TransactionID]dependency),
+		bySender:   make(map[types.UnlockHash][]types.TransactionID),
+		senderOf:   make(map[types.TransactionID]types.UnlockHash),
+	}, nil
+}
+
+// AddLocal adds a transaction originating from this node's own wallet or
+// renter. Local transactions are exempt from the fee-floor and from the
+// pool-size limit. The pool does not record which transactions were added
+// locally, so once it is full, AddRemote may evict a pending local
+// transaction just like a remote one.
+func (tp *TransactionPool) AddLocal(txn types.Transaction) error {
+	return tp.add(txn, true)
+}
+
+// AddRemote adds a transaction received from a peer. If the pool is full,
+// it's only accepted if its fee-per-byte outbids the current lowest
+// pending transaction, which is evicted to make room.
+func (tp *TransactionPool) AddRemote(txn types.Transaction) error {
+	return tp.add(txn, false)
+}
+
+// add is the shared implementation behind AddLocal/AddRemote.
+func (tp *TransactionPool) add(txn types.Transaction, local bool) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	id := txn.ID()
+	if tp.pending.lookup[id] != nil || tp.isQueued(id) {
+		return ErrDuplicateTransaction
+	}
+
+	dep, executable, err := tp.classify(txn)
+	if err != nil {
+		return err
+	}
+
+	if !local && newFeeItem(txn).feePerByte.Cmp(minFeePerByte) < 0 {
+		return ErrBelowFeeFloor
+	}
+
+	if !local && tp.size() >= maxPoolSize {
+		evictID, ok := tp.evictionCandidate(txn)
+		if !ok {
+			return ErrPoolFull
+		}
+		tp.removePending(evictID)
+	}
+
+	if !executable {
+		tp.queued[id] = txn
+		tp.queuedDeps[id] = dep
+		tp.index(txn, id)
+		return nil
+	}
+
+	tp.pending.insert(txn)
+	tp.index(txn, id)
+	tp.notify()
+	return nil
+}
+
+// evictionCandidate picks a pending transaction to drop to make room for
+// txn. It prefers evicting a lower fee-per-byte transaction from the same
+// sender as txn (via bySender) over a stranger's, falling back to the
+// pool-wide lowest fee-per-byte transaction if the sender has nothing
+// evictable. It returns false if no pending transaction has a lower
+// fee-per-byte than txn.
+func (tp *TransactionPool) evictionCandidate(txn types.Transaction) (types.TransactionID, bool) {
+	newFee := newFeeItem(txn).feePerByte
+
+	if len(txn.SiacoinInputs) > 0 {
+		sender := txn.SiacoinInputs[0].UnlockConditions.UnlockHash()
+		var worst *feeItem
+		for _, id := range tp.bySender[sender] {
+			item, ok := tp.pending.lookup[id]
+			if !ok {
+				continue
+			}
+			if worst == nil || item.feePerByte.Cmp(worst.feePerByte) < 0 {
+				worst = item
+			}
+		}
+		if worst != nil && worst.feePerByte.Cmp(newFee) < 0 {
+			return worst.txn.ID(), true
+		}
+	}
+
+	lowest, ok := tp.pending.lowestFee()
+	if !ok || newFeeItem(lowest).feePerByte.Cmp(newFee) >= 0 {
+		return types.TransactionID{}, false
+	}
+	return lowest.ID(), true
+}
+
+// classify determines whether txn is immediately executable against the
+// current consensus set. If it isn't, the returned dependency names the
+// missing output it's waiting on.
+func (tp *TransactionPool) classify(txn types.Transaction) (dependency, bool, error) {
+	if !txn.StandaloneValid(tp.cs.Height()) {
+		return dependency{}, false, ErrInvalidTransaction
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if _, exists := tp.cs.SiacoinOutput(sci.ParentID); exists {
+			continue
+		}
+		if _, producedByPool := tp.producesOutput(sci.ParentID); producedByPool {
+			return dependency{outputID: sci.ParentID}, false, nil
+		}
+		return dependency{}, false, ErrInvalidTransaction
+	}
+	return dependency{}, true, nil
+}
+
+// producesOutput reports whether any transaction already in the pool
+// (pending or queued) creates outputID, and if so returns that
+// transaction's ID.
+func (tp *TransactionPool) producesOutput(outputID types.SiacoinOutputID) (types.TransactionID, bool) {
+	for id, item := range tp.pending.lookup {
+		for i := range item.txn.SiacoinOutputs {
+			if item.txn.SiacoinOutputID(uint64(i)) == outputID {
+				return id, true
+			}
+		}
+	}
+	for id, txn := range tp.queued {
+		for i := range txn.SiacoinOutputs {
+			if txn.SiacoinOutputID(uint64(i)) == outputID {
+				return id, true
+			}
+		}
+	}
+	return types.TransactionID{}, false
+}
+
+// isQueued reports whether id is currently in the queued tier.
+func (tp *TransactionPool) isQueued(id types.TransactionID) bool {
+	_, exists := tp.queued[id]
+	return exists
+}
+
+// index records txn under its sender's unlock hash for later eviction
+// lookups. A transaction with no signed inputs (shouldn't normally happen)
+// is simply left out of the sender index.
+func (tp *TransactionPool) index(txn types.Transaction, id types.TransactionID) {
+	if len(txn.SiacoinInputs) == 0 {
+		return
+	}
+	sender := txn.SiacoinInputs[0].UnlockConditions.UnlockHash()
+	tp.bySender[sender] = append(tp.bySender[sender], id)
+	tp.senderOf[id] = sender
+}
+
+// deindex removes id from the sender index. It's a no-op if id was never
+// indexed (e.g. it had no signed inputs).
+func (tp *TransactionPool) deindex(id types.TransactionID) {
+	sender, ok := tp.senderOf[id]
+	if !ok {
+		return
+	}
+	ids := tp.bySender[sender]
+	for i, other := range ids {
+		if other == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(tp.bySender, sender)
+	} else {
+		tp.bySender[sender] = ids
+	}
+	delete(tp.senderOf, id)
+}
+
+// removePending drops id from the pending tier and its sender index.
+func (tp *TransactionPool) removePending(id types.TransactionID) {
+	tp.pending.remove(id)
+	tp.deindex(id)
+}
+
+// removeQueued drops id from the queued tier and its sender index.
+func (tp *TransactionPool) removeQueued(id types.TransactionID) {
+	delete(tp.queued, id)
+	delete(tp.queuedDeps, id)
+	tp.deindex(id)
+}
+
+// promoteQueued moves any queued transaction whose dependency now exists in
+// the consensus set into the pending tier. Callers must hold tp.mu.
+func (tp *TransactionPool) promoteQueued() {
+	for id, dep := range tp.queuedDeps {
+		if _, exists := tp.cs.SiacoinOutput(dep.outputID); !exists {
+			continue
+		}
+		txn := tp.queued[id]
+		delete(tp.queued, id)
+		delete(tp.queuedDeps, id)
+		tp.pending.insert(txn)
+	}
+}
+
+// size returns the combined number of pending and queued transactions.
+func (tp *TransactionPool) size() int {
+	return tp.pending.len() + len(tp.queued)
+}
+
+// Select returns up to maxBytes worth of pending transactions, highest
+// fee-per-byte first, for the miner to include in a block.
+func (tp *TransactionPool) Select(maxBytes uint64) []types.Transaction {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.pending.selectTop(maxBytes)
+}
+
+// Reset reorganizes the pool from oldTip to newTip: transactions that were
+// confirmed along the reverted path are re-injected as pending (their
+// outputs are, by definition, once again unspent), and transactions
+// confirmed along the new path are dropped since they're now mined.
+func (tp *TransactionPool) Reset(oldTip, newTip types.BlockID) error {
+	reverted, applied, err := tp.cs.ReorgPath(oldTip, newTip)
+	if err != nil {
+		return err
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for _, blockID := range reverted {
+		block, exists := tp.cs.Block(blockID)
+		if !exists {
+			continue
+		}
+		for _, txn := range block.Transactions {
+			tp.pending.insert(txn)
+			tp.index(txn, txn.ID())
+		}
+	}
+	for _, blockID := range applied {
+		block, exists := tp.cs.Block(blockID)
+		if !exists {
+			continue
+		}
+		for _, txn := range block.Transactions {
+			tp.removePending(txn.ID())
+			tp.removeQueued(txn.ID())
+		}
+	}
+
+	tp.promoteQueued()
+	tp.notify()
+	return nil
+}
+
+// Subscribe returns a channel on which the current set of pending
+// transactions is sent every time the pool changes, so the wallet and
+// miner can react without polling Select.
+func (tp *TransactionPool) Subscribe() <-chan []types.Transaction {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	c := make(chan []types.Transaction, 1)
+	tp.subscribers = append(tp.subscribers, c)
+	return c
+}
+
+// notify sends the current pending set to every subscriber. Callers must
+// hold tp.mu.
+func (tp *TransactionPool) notify() {
+	set := tp.pending.selectTop(^uint64(0))
+	for _, c := range tp.subscribers {
+		select {
+		case c <- set:
+		default:
+			select {
+			case <-c:
+			default:
+			}
+			c <- set
+		}
+	}
+}
+
+// encodeTransaction is a small helper so fee-per-byte math lives in one
+// place; it mirrors how the rest of the codebase sizes transactions before
+// fitting them into a block.
+func encodeTransaction(txn types.Transaction) []byte {
+	return encoding.Marshal(txn)
+}
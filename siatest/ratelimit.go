@@ -0,0 +1,57 @@
+package siatest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// uploadQPS and uploadBurst give parallel UploadNewFile calls against a
+// small host set deterministic pacing instead of all firing at once.
+const (
+	uploadQPS    = 10
+	uploadBurst  = 20
+	uploadWindow = 10 * time.Second
+)
+
+// uploadLimiters holds each TestNode's shared SubmissionRateLimiter, keyed by
+// the node itself. TestNode is defined outside this package, so rather than
+// add a field to it, its rate limiter lives in this side table guarded by
+// uploadLimitersMu.
+var (
+	uploadLimitersMu sync.Mutex
+	uploadLimiters   = make(map[*TestNode]*modules.SubmissionRateLimiter)
+)
+
+// UploadRateLimiter returns tn's shared SubmissionRateLimiter for renter
+// chunk pushes, creating and starting it on first use. The many parallel
+// UploadNewFile callers this is meant for can't race each other into
+// creating more than one limiter, since uploadLimitersMu guards the lookup.
+// Tests that want to disable pacing can call tn.UploadRateLimiter().Stop().
+func (tn *TestNode) UploadRateLimiter() *modules.SubmissionRateLimiter {
+	uploadLimitersMu.Lock()
+	defer uploadLimitersMu.Unlock()
+	limiter, ok := uploadLimiters[tn]
+	if !ok {
+		limiter = modules.NewSubmissionRateLimiter(uploadQPS, uploadBurst, uploadWindow)
+		limiter.Start()
+		uploadLimiters[tn] = limiter
+	}
+	return limiter
+}
+
+// UploadThrottled behaves like Upload, but serializes the upload through
+// tn's UploadRateLimiter instead of starting it immediately. This gives
+// deterministic pacing when many parallel UploadNewFile calls run against
+// a small host set.
+func (tn *TestNode) UploadThrottled(tf *TestFile, dataPieces, parityPieces uint64) error {
+	err := tn.UploadRateLimiter().Submit([]byte(tf.siaPath), func() error {
+		return tn.Upload(tf, dataPieces, parityPieces)
+	})
+	if err != nil {
+		return build.ExtendErr("throttled upload failed", err)
+	}
+	return nil
+}
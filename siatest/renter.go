@@ -1,10 +1,15 @@
 package siatest
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
+	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"time"
 
@@ -219,4 +224,132 @@ func (tn *TestNode) WaitForUploadRedundancy(tf *TestFile, redundancy float64) er
 		}
 		return nil
 	})
-}
\ No newline at end of file
+}
+
+// Range identifies a byte range of a file, [Offset, Offset+Length).
+type Range struct {
+	Offset uint64
+	Length uint64
+}
+
+// DownloadRange fetches [offset, offset+length) of tf from the renter,
+// returning it as a ReadCloser so callers can treat it the same as any other
+// streamed download. The caller is responsible for closing the returned
+// ReadCloser.
+func (tn *TestNode) DownloadRange(tf *TestFile, offset, length uint64) (io.ReadCloser, error) {
+	fi, err := tn.FileInfo(tf)
+	if err != nil {
+		return nil, build.ExtendErr("failed to retrieve FileInfo", err)
+	}
+	if offset+length > fi.Filesize {
+		return nil, fmt.Errorf("range [%v, %v) is out of bounds for a %v byte file", offset, offset+length, fi.Filesize)
+	}
+	data, err := tn.RenterDownloadHTTPResponseGet(tf.siaPath, offset, length)
+	if err != nil {
+		return nil, build.ExtendErr("failed to fetch download range", err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// DownloadResume inspects an existing partial file at dest, requests only
+// the missing suffix of tf, and appends it in place. If dest doesn't exist
+// yet, it behaves like a full DownloadRange starting at offset 0. Once the
+// download completes, the full file's checksum is verified against tf.
+func (tn *TestNode) DownloadResume(tf *TestFile, dest string) error {
+	fi, err := tn.FileInfo(tf)
+	if err != nil {
+		return build.ExtendErr("failed to retrieve FileInfo", err)
+	}
+
+	var have uint64
+	if stat, err := os.Stat(dest); err == nil {
+		have = uint64(stat.Size())
+	} else if !os.IsNotExist(err) {
+		return build.ExtendErr("failed to stat partial download", err)
+	}
+	if have >= fi.Filesize {
+		return nil
+	}
+
+	rc, err := tn.DownloadRange(tf, have, fi.Filesize-have)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return build.ExtendErr("failed to open partial download for resume", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, rc); err != nil {
+		return build.ExtendErr("failed to resume download", err)
+	}
+
+	resumed := &TestFile{path: dest, siaPath: tf.siaPath}
+	if err := resumed.updateChecksum(); err != nil {
+		return err
+	}
+	if resumed.Compare(tf) != 0 {
+		return errors.New("resumed file's checksum doesn't match the original")
+	}
+	return nil
+}
+
+// coalesceRanges merges ranges that are within maxGap bytes of one another
+// into single, larger ranges, so adjacent or overlapping requests can be
+// served by one host RPC instead of many. ranges need not be sorted; the
+// result is sorted by offset.
+func coalesceRanges(ranges []Range, maxGap uint64) []Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := make([]Range, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	merged := []Range{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		lastEnd := last.Offset + last.Length
+		if r.Offset <= lastEnd+maxGap {
+			if end := r.Offset + r.Length; end > lastEnd {
+				last.Length = end - last.Offset
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// DownloadRanges fetches multiple byte ranges of tf, coalescing nearby
+// ranges into single host RPCs rather than issuing one request per range.
+// The returned slice has one entry per requested range, in the same order
+// as ranges, regardless of how the underlying fetches were batched.
+func (tn *TestNode) DownloadRanges(tf *TestFile, ranges []Range) ([][]byte, error) {
+	const maxCoalesceGap = 64 << 10 // merge ranges separated by up to 64 KiB
+	merged := coalesceRanges(ranges, maxCoalesceGap)
+
+	fetched := make([][]byte, len(merged))
+	for i, r := range merged {
+		data, err := tn.RenterDownloadHTTPResponseGet(tf.siaPath, r.Offset, r.Length)
+		if err != nil {
+			return nil, build.ExtendErr("failed to fetch coalesced range", err)
+		}
+		fetched[i] = data
+	}
+
+	results := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		for j, m := range merged {
+			if r.Offset >= m.Offset && r.Offset+r.Length <= m.Offset+m.Length {
+				start := r.Offset - m.Offset
+				results[i] = fetched[j][start : start+r.Length]
+				break
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,25 @@
+package siatest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// WaitForSync blocks until the node's consensus set has processed blocks up
+// to targetHeight, polling the height the same way WaitForDownload and
+// WaitForUploadProgress poll their own progress counters.
+func (tn *TestNode) WaitForSync(targetHeight types.BlockHeight) error {
+	return Retry(1000, 100*time.Millisecond, func() error {
+		cg, err := tn.ConsensusGet()
+		if err != nil {
+			return build.ExtendErr("couldn't retrieve ConsensusGet", err)
+		}
+		if cg.Height < targetHeight {
+			return errors.New("node hasn't reached the target height yet")
+		}
+		return nil
+	})
+}